@@ -0,0 +1,167 @@
+package caskdb
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+)
+
+// batchSeqCounter hands out monotonically increasing, process-unique batch
+// sequence numbers. Every record written by one Batch.Commit carries the
+// same sequence number in its header, so initKeyDirInternal can recognise
+// records belonging to the same batch on recovery.
+var batchSeqCounter uint32
+
+func nextBatchSeq() uint32 {
+	return atomic.AddUint32(&batchSeqCounter, 1)
+}
+
+// batchOp is a single buffered Set or Delete awaiting Commit.
+type batchOp struct {
+	key       string
+	value     string
+	tombstone bool
+}
+
+// Batch buffers a sequence of Set/Delete calls and applies them to the
+// store as one contiguous, atomically-visible write: every record is
+// appended to the active file back to back, a single fsync covers the
+// whole run (no fsync at all under SyncNever), and keyDir is only updated
+// once that fsync succeeds. This
+// amortises the fsync cost across many writes instead of paying it once per
+// key, at the cost of all the batch's writes becoming visible together
+// rather than incrementally.
+//
+// A Batch is not safe for concurrent use; build it from one goroutine and
+// call Commit once.
+type Batch struct {
+	store *DiskStore
+	ops   []batchOp
+}
+
+// NewBatch returns an empty Batch bound to d.
+func (d *DiskStore) NewBatch() *Batch {
+	return &Batch{store: d}
+}
+
+// Set buffers a key/value write. It has no effect until Commit succeeds.
+func (b *Batch) Set(key, value string) {
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+}
+
+// Delete buffers a tombstone write. It has no effect until Commit succeeds.
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, batchOp{key: key, tombstone: true})
+}
+
+type encodedBatchOp struct {
+	key       string
+	timestamp uint32
+	size      uint32
+	data      []byte
+	tombstone bool
+}
+
+// Commit encodes every buffered op, appends them to the active file as one
+// contiguous run tagged with a shared batch sequence number, and fsyncs
+// once for the whole run unless the store's sync mode is SyncNever, in
+// which case it does not fsync. keyDir is only updated after that fsync
+// succeeds, so a failure midway leaves the store exactly as it was before
+// Commit was called. Calling Commit on an empty or already-committed Batch
+// is a no-op.
+func (b *Batch) Commit() error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	if b.store.readOnly {
+		return ErrReadOnly
+	}
+
+	seq := nextBatchSeq()
+	count := uint32(len(b.ops))
+	records := make([]encodedBatchOp, 0, count)
+	var totalSize int
+
+	for _, op := range b.ops {
+		if !op.tombstone {
+			if err := validateKV(op.key, []byte(op.value), b.store.maxKeySize, b.store.maxValueSize); err != nil {
+				return err
+			}
+		}
+
+		timestamp := uint32(time.Now().Unix())
+		value := op.value
+		if op.tombstone {
+			value = ""
+		}
+
+		h := Header{TimeStamp: timestamp, KeySize: uint32(len(op.key)), ValueSize: uint32(len(value))}
+		h.BatchSeq = seq
+		h.BatchCount = count
+		if op.tombstone {
+			h.MarkTombStone()
+		}
+
+		r := Record{Header: h, Key: op.key, Value: value, RecordSize: headerSize + h.KeySize + h.ValueSize}
+		r.Header.CheckSum = r.CalculateCheckSum()
+
+		buf := new(bytes.Buffer)
+		if err := r.EncodeKV(buf); err != nil {
+			return ErrEncodingFailed
+		}
+
+		records = append(records, encodedBatchOp{
+			key:       op.key,
+			timestamp: timestamp,
+			size:      r.Size(),
+			data:      buf.Bytes(),
+			tombstone: op.tombstone,
+		})
+		totalSize += len(buf.Bytes())
+	}
+
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+
+	if err := b.store.checkMaxFileSizeReached(totalSize); err != nil {
+		return err
+	}
+
+	fileID := b.store.activeFileID
+	position := uint32(b.store.writePosition)
+	positions := make([]uint32, len(records))
+
+	for i, rec := range records {
+		if err := b.store.appendNoSync(rec.data); err != nil {
+			return err
+		}
+		positions[i] = position
+		position += rec.size
+	}
+
+	if b.store.syncMode != SyncNever {
+		if err := b.store.file.Sync(); err != nil {
+			return err
+		}
+	} else {
+		b.store.dirty = true
+	}
+
+	for i, rec := range records {
+		if old, ok := b.store.keyDir[rec.key]; ok {
+			b.store.recordDeadBytesLocked(old.fileID, old.totalSize)
+		}
+		if rec.tombstone {
+			b.store.removeEntryLocked(rec.key)
+			b.store.clearExpiryLocked(rec.key)
+			continue
+		}
+		b.store.indexEntryLocked(rec.key, NewKeyEntry(rec.timestamp, fileID, positions[i], rec.size))
+		// Batch has no TTL concept of its own, so a batched Set always
+		// clears whatever TTL the key previously had, same as a plain Set.
+		b.store.clearExpiryLocked(rec.key)
+	}
+
+	b.ops = nil
+	return nil
+}
@@ -3,13 +3,15 @@ package caskdb
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -25,9 +27,36 @@ import (
 // https://pkg.go.dev/os#File.Seek
 const defaultWhence = 0
 
-// MaxFileSize
-// while writing to file if max file size reached, new file will be created
-var MaxFileSize int64 = 1 << 30
+// bitcaskDataSuffix is the extension used for immutable and active data
+// files; mergedSuffix is additionally inserted into the name of data files
+// produced by Merge so they are easy to tell apart from un-merged ones.
+const (
+	bitcaskDataSuffix = ".bitcask.data"
+	mergedSuffix      = ".merged"
+)
+
+// initialFileID is the fileID assigned to the very first data file a store
+// creates.
+const initialFileID uint32 = 1000000000
+
+// fileIDPattern extracts the numeric file id that every bitcask data and
+// hint file name is prefixed with, e.g. "1000000002.bitcask.data" ->
+// "1000000002". It anchors to the start of the name rather than requiring
+// ".bitcask" to immediately follow the digits, so it also matches merged
+// file names like "1000000002.merged.bitcask.data".
+var fileIDPattern = regexp.MustCompile(`^(\d+)`)
+
+func fileIDFromName(name string) (uint32, error) {
+	matches := fileIDPattern.FindStringSubmatch(name)
+	if matches == nil {
+		return 0, fmt.Errorf("caskdb: %q is not a bitcask data/hint file name", name)
+	}
+	id, err := strconv.ParseUint(matches[1], 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(id), nil
+}
 
 // DiskStore is a Log-Structured Hash Table as described in the BitCask paper. We
 // keep appending the data to a file, like a log. DiskStorage maintains an in-memory
@@ -64,22 +93,90 @@ var MaxFileSize int64 = 1 << 30
 // accordingly. The initialisation is also a blocking operation; till it is completed,
 // we cannot use the database.
 //
+// DiskStore is safe for concurrent use by multiple goroutines. Reads never
+// share a file handle with the writer: the active file is append-only and
+// is never seeked, and every KeyEntry resolves its data through a read-only
+// handle from readFiles rather than through the writer's handle, so Get
+// never races with Set/Delete and survives active-file rotation. Get does
+// still wait on mu while a Set or Delete holds it for its write and fsync.
+//
 // Typical usage example:
 //
 //		store, _ := NewDiskStore("books.db")
 //	   	store.Set("othello", "shakespeare")
 //	   	author := store.Get("othello")
 type DiskStore struct {
+	// mu guards every field below, including the contents (but not the
+	// existence) of the *os.File handles in readFiles.
+	mu sync.RWMutex
+
 	// directory name that contains all data files
 	dir string
-	// file object pointing the file_name
+	// file is the active, append-only writer file. It is never seeked.
 	file *os.File
+	// activeFileID is the fileID of file.
+	activeFileID uint32
+	// nextFileID is the next fileID available for a new data file, whether
+	// created by rotation (createNewDataFile) or by Merge's compacted
+	// output. Allocating from this single counter, rather than deriving a
+	// new id from activeFileID in each of those two places separately,
+	// guarantees a merge's compacted file id can never later collide with
+	// a rotated active file id.
+	nextFileID uint32
 	// current cursor position in the file where the data can be written
 	writePosition int
 	// keyDir is a map of key and KeyEntry being the value. KeyEntry contains the position
 	// of the byte offset in the file where the value exists. key_dir map acts as in-memory
 	// index to fetch the values quickly from the disk
 	keyDir map[string]KeyEntry
+	// keys mirrors the set of keys in keyDir in a radix tree, so Scan,
+	// Range and Fold can serve ordered and prefix queries without scanning
+	// every entry in keyDir.
+	keys *radixTree
+	// readFiles holds one read-only handle per data file (including the
+	// active one), keyed by fileID. Reads use File.ReadAt against these
+	// handles instead of Seek+Read, so they never race with the writer and
+	// never dangle after the active file rotates.
+	readFiles map[uint32]*os.File
+	// deadBytes tracks, per data file fileID, how many bytes are occupied
+	// by records that Set/Delete have since superseded. Merge uses this to
+	// decide which files are worth compacting.
+	deadBytes map[uint32]int64
+
+	// maxFileSize is the size at which the active file is rotated.
+	maxFileSize int64
+	// maxKeySize and maxValueSize bound what Set (and Batch.Set) will
+	// accept; Delete does not check them.
+	maxKeySize   uint32
+	maxValueSize uint32
+	// syncMode controls when writes are fsynced; see SyncMode.
+	syncMode SyncMode
+	// dirty is set by write and cleared by the interval-sync goroutine; it
+	// is only meaningful when syncMode == SyncInterval.
+	dirty bool
+	// syncStop, when non-nil, terminates the interval-sync goroutine.
+	syncStop chan struct{}
+	// autoMerge holds the background-merge configuration set via
+	// WithAutoMerge; a zero Interval means auto-merge is disabled.
+	autoMerge AutoMergeOptions
+	// mergeStop, when non-nil, terminates the auto-merge goroutine.
+	mergeStop func()
+	// readOnly, when set via WithReadOnly, makes Set/Delete/Merge fail with
+	// ErrReadOnly and skips taking the directory lock.
+	readOnly bool
+	// lockFile holds the flock'd handle on dir/LOCK for the lifetime of the
+	// store; it is released in Close.
+	lockFile *os.File
+
+	// expiry is a min-heap of keys with a TTL, ordered by expiration time,
+	// so the background sweeper can find the next key due to evict without
+	// scanning keyDir. expiryIndex holds each such key's current expiry
+	// time; it is the source of truth, since a key's TTL can be overwritten
+	// or cleared without removing its old entries from expiry. See ttl.go.
+	expiry      expiryHeap
+	expiryIndex map[string]uint32
+	// ttlStop terminates the background TTL sweeper goroutine.
+	ttlStop func()
 }
 
 func isFileExists(fileName string) bool {
@@ -90,7 +187,12 @@ func isFileExists(fileName string) bool {
 	return false
 }
 
-func NewDiskStore(directoryName string) (*DiskStore, error) {
+// NewDiskStore opens (creating if necessary) a Bitcask-style store rooted at
+// directoryName. Callers configure size limits, sync behaviour, background
+// merging and read-only access via Option values; see WithMaxFileSize,
+// WithMaxKeySize, WithMaxValueSize, WithSyncMode, WithAutoMerge and
+// WithReadOnly.
+func NewDiskStore(directoryName string, opts ...Option) (*DiskStore, error) {
 	if !isFileExists(directoryName) {
 		err := os.MkdirAll(directoryName, os.ModePerm)
 
@@ -100,20 +202,79 @@ func NewDiskStore(directoryName string) (*DiskStore, error) {
 	}
 
 	ds := &DiskStore{
-		dir:    directoryName,
-		keyDir: make(map[string]KeyEntry),
+		dir:          directoryName,
+		keyDir:       make(map[string]KeyEntry),
+		keys:         newRadixTree(),
+		readFiles:    make(map[uint32]*os.File),
+		maxFileSize:  defaultMaxFileSize,
+		maxKeySize:   defaultMaxKeySize,
+		maxValueSize: defaultMaxValueSize,
+		syncMode:     SyncAlways,
 	}
 
-	err := ds.initKeyDir(directoryName)
+	for _, opt := range opts {
+		opt(ds)
+	}
 
-	if err != nil {
-		log.Fatalf("error while loading the keys from disk: %v", err)
+	if !ds.readOnly {
+		lockFile, err := acquireLock(directoryName)
+		if err != nil {
+			return nil, err
+		}
+		ds.lockFile = lockFile
+	}
+
+	if err := ds.initKeyDir(directoryName); err != nil {
+		if ds.lockFile != nil {
+			releaseLock(ds.lockFile)
+		}
+		return nil, fmt.Errorf("caskdb: loading keys from disk: %w", err)
 	}
 
+	if ds.syncMode == SyncInterval {
+		ds.startIntervalSync(intervalSyncPeriod)
+	}
+
+	if ds.autoMerge.Interval > 0 {
+		ds.mergeStop = ds.StartAutoMerge(ds.autoMerge)
+	}
+
+	ds.ttlStop = ds.startTTLSweeper(ttlSweepInterval)
+
 	return ds, nil
 }
 
+// Get retrieves key's value. A key whose TTL (see SetWithTTL, Expire) has
+// passed is treated as absent: Get returns ErrKeyNotFound and lazily
+// tombstones it, same as the background sweeper would do on its own, so a
+// store that is never swept doesn't keep serving ErrKeyNotFound off a
+// keyDir entry that never gets reclaimed.
 func (d *DiskStore) Get(key string) (string, error) {
+	d.mu.RLock()
+	value, expired, err := d.getLockedExpiry(key)
+	d.mu.RUnlock()
+
+	if expired {
+		d.evictExpired(key)
+	}
+	return value, err
+}
+
+// getLocked is Get's implementation minus lazy eviction; callers must hold
+// d.mu for reading. It is split out so Range and Fold can fetch values for
+// many keys under a single lock acquisition instead of one per key, and so
+// an expired key can be treated as absent there without trying to upgrade
+// their held RLock to a write lock.
+func (d *DiskStore) getLocked(key string) (string, error) {
+	value, _, err := d.getLockedExpiry(key)
+	return value, err
+}
+
+// getLockedExpiry is getLocked's implementation; it additionally reports
+// whether key was found but had an expired TTL, so Get can trigger lazy
+// eviction after releasing its read lock. Callers must hold d.mu for
+// reading.
+func (d *DiskStore) getLockedExpiry(key string) (value string, expired bool, err error) {
 	// Get retrieves the value from the disk and returns. If the key does not
 	// exist then it returns an empty string
 	//
@@ -121,38 +282,38 @@ func (d *DiskStore) Get(key string) (string, error) {
 	//	1. Check if there is any KeyEntry record for the key in keyDir
 	//	2. Return an empty string if key doesn't exist or if the key has been deleted
 	//	3. If it exists, then read KeyEntry.totalSize bytes starting from the
-	//     KeyEntry.position from the disk
+	//     KeyEntry.position from the disk, via the read-only handle for its file
 	//	4. Decode the bytes into valid KV pair and return the value
 	//
 	kEntry, ok := d.keyDir[key]
 	if !ok {
-		return "", ErrKeyNotFound
+		return "", false, ErrKeyNotFound
 	}
-
-	// move the current pointer to the right offset
-	_, err := kEntry.file.Seek(int64(kEntry.position), defaultWhence)
-	if err != nil {
-		return "", ErrSeekFailed
+	if d.expiredLocked(key) {
+		return "", true, ErrKeyNotFound
+	}
+	rf, ok := d.readFiles[kEntry.fileID]
+	if !ok {
+		return "", false, ErrReadFailed
 	}
 
 	data := make([]byte, kEntry.totalSize)
-	_, err = io.ReadFull(kEntry.file, data)
-	if err != nil {
-		return "", ErrReadFailed
+	if _, err := rf.ReadAt(data, int64(kEntry.position)); err != nil {
+		return "", false, ErrReadFailed
 	}
 
 	result := &Record{}
 	err = result.DecodeKV(data)
 	if err != nil {
-		return "", ErrDecodingFailed
+		return "", false, ErrDecodingFailed
 	}
 
 	//validate if the checksum matches means the value is not corrupted
 	if !result.VerifyCheckSum(data) {
-		return "", ErrChecksumMismatch
+		return "", false, ErrChecksumMismatch
 	}
 
-	return result.Value, nil
+	return result.Value, false, nil
 }
 
 func (d *DiskStore) Set(key string, value string) error {
@@ -162,13 +323,23 @@ func (d *DiskStore) Set(key string, value string) error {
 	// 1. Encode the KV into bytes
 	// 2. Write the bytes to disk by appending to the file
 	// 3. Update KeyDir with the KeyEntry of this key
+	return d.set(key, value, 0)
+}
+
+// set is Set and SetWithTTL's shared implementation. expiresAt is the Unix
+// timestamp the record should expire at, or 0 for a record that never
+// expires.
+func (d *DiskStore) set(key string, value string, expiresAt uint32) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
 
-	if err := validateKV(key, []byte(value)); err != nil {
+	if err := validateKV(key, []byte(value), d.maxKeySize, d.maxValueSize); err != nil {
 		return err
 	}
 
 	timestamp := uint32(time.Now().Unix())
-	h := Header{TimeStamp: timestamp, KeySize: uint32(len(key)), ValueSize: uint32(len(value))}
+	h := Header{TimeStamp: timestamp, KeySize: uint32(len(key)), ValueSize: uint32(len(value)), ExpiresAt: expiresAt}
 	r := Record{Header: h, Key: key, Value: value, RecordSize: headerSize + h.KeySize + h.ValueSize}
 	r.Header.CheckSum = r.CalculateCheckSum()
 
@@ -178,9 +349,24 @@ func (d *DiskStore) Set(key string, value string) error {
 	if err != nil {
 		return ErrEncodingFailed
 	}
-	d.write(buf.Bytes())
 
-	d.keyDir[key] = NewKeyEntry(timestamp, d.file, uint32(d.writePosition), r.Size())
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if old, ok := d.keyDir[key]; ok {
+		d.recordDeadBytesLocked(old.fileID, old.totalSize)
+	}
+
+	d.indexEntryLocked(key, NewKeyEntry(timestamp, d.activeFileID, uint32(d.writePosition), r.Size()))
+	if expiresAt != 0 {
+		d.trackExpiryLocked(key, expiresAt)
+	} else {
+		d.clearExpiryLocked(key)
+	}
 	// update last write position, so that next record can be written from this point
 	d.writePosition += int(r.Size())
 
@@ -195,7 +381,7 @@ func (d *DiskStore) checkMaxFileSizeReached(size int) error {
 
 	stat, _ := d.file.Stat()
 	nextSize := stat.Size() + int64(size)
-	if nextSize > MaxFileSize {
+	if nextSize > d.maxFileSize {
 		err := d.createNewDataFile()
 
 		return err
@@ -204,19 +390,61 @@ func (d *DiskStore) checkMaxFileSizeReached(size int) error {
 	return nil
 }
 
+// createNewDataFile rotates the active writer file: the current active file
+// (if any) is fsynced, closed for writing, and reopened as a read-only
+// handle in readFiles so that existing KeyEntry records pointing at it keep
+// working. A brand new file then becomes the active writer, with its own
+// read-only handle registered up front so records written to it are
+// readable immediately.
 func (d *DiskStore) createNewDataFile() error {
-	activeFile := createFilenameId(d.file.Name()) + ".bitcask.data"
-	file, err := os.Create(filepath.Join(d.dir, activeFile))
+	nextID := d.nextFileID
+	if nextID == 0 {
+		nextID = initialFileID
+	}
+	if d.file != nil {
+		if err := d.file.Sync(); err != nil {
+			return err
+		}
+		oldPath := d.file.Name()
+		if err := d.file.Close(); err != nil {
+			return err
+		}
+
+		oldReadFile, err := os.Open(oldPath)
+		if err != nil {
+			return err
+		}
+		d.readFiles[d.activeFileID] = oldReadFile
+	}
+
+	activeFile := strconv.FormatUint(uint64(nextID), 10) + bitcaskDataSuffix
+	activePath := filepath.Join(d.dir, activeFile)
+
+	file, err := os.Create(activePath)
+	if err != nil {
+		return err
+	}
+
+	readFile, err := os.Open(activePath)
 	if err != nil {
+		file.Close()
 		return err
 	}
+
 	d.file = file
+	d.activeFileID = nextID
+	d.nextFileID = nextID + 1
 	d.writePosition = 0
+	d.readFiles[nextID] = readFile
 
 	return nil
 }
 
 func (d *DiskStore) Delete(key string) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
 	timestamp := uint32(time.Now().Unix())
 	value := ""
 	h := Header{TimeStamp: timestamp, KeySize: uint32(len(key)), ValueSize: uint32(len(value))}
@@ -231,14 +459,39 @@ func (d *DiskStore) Delete(key string) error {
 	if err != nil {
 		return err
 	}
-	d.write(buf.Bytes())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.write(buf.Bytes()); err != nil {
+		return err
+	}
+	d.writePosition += int(r.Size())
+
+	if old, ok := d.keyDir[key]; ok {
+		d.recordDeadBytesLocked(old.fileID, old.totalSize)
+	}
 
 	//delete the key from the hash table
-	delete(d.keyDir, key)
+	d.removeEntryLocked(key)
+	d.clearExpiryLocked(key)
 	return nil
 }
 
 func (d *DiskStore) Close() bool {
+	if d.ttlStop != nil {
+		d.ttlStop()
+	}
+	if d.mergeStop != nil {
+		d.mergeStop()
+	}
+	if d.syncStop != nil {
+		close(d.syncStop)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	// before we close the file, we need to safely write the contents in the buffers
 	// to the disk. Check documentation of DiskStore.write() to understand
 	// following the operations
@@ -250,15 +503,23 @@ func (d *DiskStore) Close() bool {
 			return false
 		}
 	}
-	for _, v := range d.keyDir {
-		v.file.Close()
+	for _, rf := range d.readFiles {
+		rf.Close()
+	}
+	if err := releaseLock(d.lockFile); err != nil {
+		// TODO: log the error
+		return false
 	}
 	return true
 }
 
-func (d *DiskStore) write(data []byte) {
+// write appends data to the active file and, depending on syncMode, fsyncs
+// it. Callers must hold d.mu for writing. Any error from rotating,
+// appending or syncing is returned rather than panicking, so a failed
+// write surfaces to the caller instead of crashing the process.
+func (d *DiskStore) write(data []byte) error {
 	if err := d.checkMaxFileSizeReached(len(data)); err != nil {
-		panic(err)
+		return err
 	}
 
 	// saving stuff to a file reliably is hard!
@@ -266,13 +527,37 @@ func (d *DiskStore) write(data []byte) {
 	// start from here: https://danluu.com/file-consistency/
 	// and read this too: https://lwn.net/Articles/457667/
 	if _, err := d.file.Write(data); err != nil {
-		panic(err)
+		return err
 	}
-	// calling fsync after every write is important, this assures that our writes
-	// are actually persisted to the disk
-	if err := d.file.Sync(); err != nil {
-		panic(err)
+
+	switch d.syncMode {
+	case SyncAlways:
+		// calling fsync after every write is important, this assures that
+		// our writes are actually persisted to the disk
+		if err := d.file.Sync(); err != nil {
+			return err
+		}
+	case SyncInterval:
+		d.dirty = true
+	case SyncNever:
+		// durability is left to the OS page cache and an eventual Close
 	}
+
+	return nil
+}
+
+// appendNoSync appends data to the active file without rotating or
+// syncing, advancing writePosition. It is the low-level primitive Batch
+// uses to lay down a whole batch's records as one contiguous run before
+// issuing a single fsync for all of them. Callers must hold d.mu for
+// writing and must have already ensured the active file has room via
+// checkMaxFileSizeReached.
+func (d *DiskStore) appendNoSync(data []byte) error {
+	if _, err := d.file.Write(data); err != nil {
+		return err
+	}
+	d.writePosition += len(data)
+	return nil
 }
 
 func (d *DiskStore) initKeyDir(directoryName string) error {
@@ -281,60 +566,137 @@ func (d *DiskStore) initKeyDir(directoryName string) error {
 		return err
 	}
 
+	haveDataFiles := false
+
 	for _, entry := range dirEntries {
-		if entry.IsDir() {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), bitcaskDataSuffix) {
 			continue
 		}
+		haveDataFiles = true
 
-		err = initKeyDirInternal(d.keyDir, filepath.Join(d.dir, entry.Name()))
+		dataPath := filepath.Join(d.dir, entry.Name())
+		fileID, err := fileIDFromName(entry.Name())
 		if err != nil {
 			return err
 		}
+
+		readFile, err := os.Open(dataPath)
+		if err != nil {
+			return err
+		}
+		d.readFiles[fileID] = readFile
+
+		hintPath := hintFileName(dataPath)
+		if isFileExists(hintPath) {
+			err = initKeyDirFromHint(d, fileID, hintPath)
+		} else {
+			err = initKeyDirInternal(d, fileID, readFile)
+		}
+		if err != nil {
+			return err
+		}
+
+		if fileID >= d.activeFileID {
+			d.activeFileID = fileID
+		}
+	}
+
+	if d.readOnly {
+		return nil
 	}
 
-	fileName := createFilenameId("") + ".bitcask.data"
+	fileName := strconv.FormatUint(uint64(initialFileID), 10) + bitcaskDataSuffix
+	nextID := initialFileID
 
-	if len(dirEntries) > 0 {
-		fileName = createFilenameId(dirEntries[len(dirEntries)-1].Name()) + ".bitcask.data"
+	if haveDataFiles {
+		nextID = d.activeFileID + 1
+		fileName = strconv.FormatUint(uint64(nextID), 10) + bitcaskDataSuffix
 	}
 
 	file, err := os.Create(filepath.Join(d.dir, fileName))
 	if err != nil {
 		return err
 	}
+
+	readFile, err := os.Open(filepath.Join(d.dir, fileName))
+	if err != nil {
+		file.Close()
+		return err
+	}
+
 	d.file = file
+	d.activeFileID = nextID
+	d.nextFileID = nextID + 1
 	d.writePosition = 0
+	d.readFiles[nextID] = readFile
 
 	return nil
 }
 
+// createFilenameId returns the next data file id (as a string) after the
+// one embedded in filename, or the first id if filename is empty.
 func createFilenameId(filename string) string {
 	if filename == "" {
-		return "1000000000"
+		return strconv.FormatUint(uint64(initialFileID), 10)
+	}
+
+	id, err := fileIDFromName(filename)
+	if err != nil {
+		return strconv.FormatUint(uint64(initialFileID), 10)
 	}
-	pattern := regexp.MustCompile(`(\d+)\.bitcask`)
-	matches := pattern.FindStringSubmatch(filename)
 
-	filenameId, _ := strconv.Atoi(matches[1])
+	return strconv.FormatUint(uint64(id)+1, 10)
+}
 
-	return strconv.Itoa(filenameId + 1)
+// pendingBatchEntry is one record from an as-yet-incomplete batch seen
+// during recovery; it is only applied to keyDir once every record from its
+// batch has been read.
+type pendingBatchEntry struct {
+	key       string
+	entry     KeyEntry
+	tombstone bool
+	expiresAt uint32
 }
 
-func initKeyDirInternal(keyDir map[string]KeyEntry, existingFile string) error {
+func initKeyDirInternal(d *DiskStore, fileID uint32, file *os.File) error {
 	// we will initialise the keyDir by reading the contents of the file, record by
 	// record. As we read each record, we will also update our keyDir with the
 	// corresponding KeyEntry
 	//
 	// NOTE: this method is a blocking one, if the DB size is yuge then it will take
 	// a lot of time to startup
-	file, _ := os.Open(existingFile)
 	writePosition := 0
 
+	var pendingSeq uint32
+	var pendingCount uint32
+	var pending []pendingBatchEntry
+
+	applyPending := func() {
+		for _, p := range pending {
+			if p.tombstone {
+				d.removeEntryLocked(p.key)
+				d.clearExpiryLocked(p.key)
+			} else {
+				d.indexEntryLocked(p.key, p.entry)
+				if p.expiresAt != 0 {
+					d.trackExpiryLocked(p.key, p.expiresAt)
+				} else {
+					d.clearExpiryLocked(p.key)
+				}
+			}
+		}
+		pending = nil
+		pendingSeq, pendingCount = 0, 0
+	}
+
 	for {
 		header := make([]byte, headerSize)
 		_, err := io.ReadFull(file, header)
 
 		if err == io.EOF {
+			// Anything still pending belongs to a batch whose fsync never
+			// completed (or was truncated mid-write by a crash); it is
+			// never applied to keyDir.
 			break
 		}
 		if err != nil {
@@ -360,17 +722,54 @@ func initKeyDirInternal(keyDir map[string]KeyEntry, existingFile string) error {
 		}
 
 		totalSize := headerSize + h.KeySize + h.ValueSize
-		keyDir[string(key)] = NewKeyEntry(h.TimeStamp, file, uint32(writePosition), totalSize)
+		entry := NewKeyEntry(h.TimeStamp, fileID, uint32(writePosition), totalSize)
+
+		if h.BatchSeq == 0 {
+			// A dangling partial batch followed by a standalone write means
+			// the batch was abandoned; drop it rather than guess at intent.
+			pending = nil
+			pendingSeq, pendingCount = 0, 0
+
+			if h.Tombstone {
+				d.removeEntryLocked(string(key))
+				d.clearExpiryLocked(string(key))
+			} else {
+				d.indexEntryLocked(string(key), entry)
+				if h.ExpiresAt != 0 {
+					d.trackExpiryLocked(string(key), h.ExpiresAt)
+				} else {
+					d.clearExpiryLocked(string(key))
+				}
+			}
+		} else {
+			if h.BatchSeq != pendingSeq {
+				pending = nil
+				pendingSeq = h.BatchSeq
+				pendingCount = h.BatchCount
+			}
+			pending = append(pending, pendingBatchEntry{key: string(key), entry: entry, tombstone: h.Tombstone, expiresAt: h.ExpiresAt})
+			if uint32(len(pending)) == pendingCount {
+				applyPending()
+			}
+		}
+
 		writePosition += int(totalSize)
 	}
+
 	return nil
 }
 
 // returns a list of the current keys
 func (d *DiskStore) ListKeys() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	result := make([]string, 0, len(d.keyDir))
 
 	for k := range d.keyDir {
+		if d.expiredLocked(k) {
+			continue
+		}
 		result = append(result, k)
 	}
 
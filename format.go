@@ -0,0 +1,180 @@
+package caskdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// Errors returned by Get, Set, Delete and Batch.Commit when a record
+// cannot be found, read, encoded or decoded, or fails its checksum.
+var (
+	ErrKeyNotFound      = errors.New("caskdb: key not found")
+	ErrReadFailed       = errors.New("caskdb: failed to read record from disk")
+	ErrEncodingFailed   = errors.New("caskdb: failed to encode record")
+	ErrDecodingFailed   = errors.New("caskdb: failed to decode record")
+	ErrChecksumMismatch = errors.New("caskdb: record checksum mismatch, data may be corrupt")
+	ErrKeyTooLarge      = errors.New("caskdb: key exceeds maximum size")
+	ErrValueTooLarge    = errors.New("caskdb: value exceeds maximum size")
+	ErrEmptyKey         = errors.New("caskdb: key must not be empty")
+)
+
+// tombstoneFlag marks a record as a deletion tombstone in Header's on-disk
+// flags byte.
+const tombstoneFlag byte = 1 << 0
+
+// headerSize is the fixed-size, on-disk encoding of a Header: CheckSum,
+// TimeStamp, ExpiresAt, BatchSeq, BatchCount, KeySize and ValueSize (4
+// bytes each) followed by a 1-byte flags field.
+const headerSize uint32 = 4*7 + 1
+
+// Header is the fixed-size portion of every record written to a data
+// file. It is followed on disk by the record's key and value bytes.
+//
+//   - ExpiresAt is the Unix timestamp the record expires at, or 0 if it
+//     never does; see SetWithTTL and Expire.
+//   - BatchSeq and BatchCount tag every record written by one
+//     Batch.Commit call with the same sequence number and the total
+//     number of records in that batch, so initKeyDirInternal can tell a
+//     complete batch from one truncated by a crash; both are 0 outside a
+//     batch.
+type Header struct {
+	CheckSum   uint32
+	TimeStamp  uint32
+	ExpiresAt  uint32
+	BatchSeq   uint32
+	BatchCount uint32
+	KeySize    uint32
+	ValueSize  uint32
+	Tombstone  bool
+}
+
+// MarkTombStone flags the header as a deletion tombstone.
+func (h *Header) MarkTombStone() {
+	h.Tombstone = true
+}
+
+// NewHeader decodes the fixed-size header encoded at the front of every
+// record. data must be exactly headerSize bytes, as read by
+// initKeyDirInternal straight off disk.
+func NewHeader(data []byte) (Header, error) {
+	if uint32(len(data)) != headerSize {
+		return Header{}, ErrDecodingFailed
+	}
+
+	h := Header{
+		CheckSum:   binary.LittleEndian.Uint32(data[0:4]),
+		TimeStamp:  binary.LittleEndian.Uint32(data[4:8]),
+		ExpiresAt:  binary.LittleEndian.Uint32(data[8:12]),
+		BatchSeq:   binary.LittleEndian.Uint32(data[12:16]),
+		BatchCount: binary.LittleEndian.Uint32(data[16:20]),
+		KeySize:    binary.LittleEndian.Uint32(data[20:24]),
+		ValueSize:  binary.LittleEndian.Uint32(data[24:28]),
+		Tombstone:  data[28]&tombstoneFlag != 0,
+	}
+
+	return h, nil
+}
+
+// encode returns h's fixed-size on-disk representation.
+func (h Header) encode() []byte {
+	buf := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(buf[0:4], h.CheckSum)
+	binary.LittleEndian.PutUint32(buf[4:8], h.TimeStamp)
+	binary.LittleEndian.PutUint32(buf[8:12], h.ExpiresAt)
+	binary.LittleEndian.PutUint32(buf[12:16], h.BatchSeq)
+	binary.LittleEndian.PutUint32(buf[16:20], h.BatchCount)
+	binary.LittleEndian.PutUint32(buf[20:24], h.KeySize)
+	binary.LittleEndian.PutUint32(buf[24:28], h.ValueSize)
+	if h.Tombstone {
+		buf[28] |= tombstoneFlag
+	}
+	return buf
+}
+
+// Record is one complete on-disk entry: a Header followed by its key and
+// value bytes. RecordSize is the total encoded length (header + key +
+// value) and is what callers store in a KeyEntry to read the record back.
+type Record struct {
+	Header     Header
+	Key        string
+	Value      string
+	RecordSize uint32
+}
+
+// Size returns the record's total encoded length.
+func (r *Record) Size() uint32 {
+	return r.RecordSize
+}
+
+// CalculateCheckSum computes the checksum that Header.CheckSum should hold
+// for r's current Key and Value, so the disk copy can later be verified
+// with VerifyCheckSum.
+func (r *Record) CalculateCheckSum() uint32 {
+	crc := crc32.NewIEEE()
+	_, _ = io.WriteString(crc, r.Key)
+	_, _ = io.WriteString(crc, r.Value)
+	return crc.Sum32()
+}
+
+// EncodeKV writes r's header, key and value to w, in the order
+// DecodeKV expects to read them back.
+func (r *Record) EncodeKV(w io.Writer) error {
+	if _, err := w.Write(r.Header.encode()); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, r.Key); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, r.Value); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DecodeKV parses data, a complete encoded record (header + key + value),
+// into r.
+func (r *Record) DecodeKV(data []byte) error {
+	if uint32(len(data)) < headerSize {
+		return ErrDecodingFailed
+	}
+
+	h, err := NewHeader(data[:headerSize])
+	if err != nil {
+		return err
+	}
+
+	rest := data[headerSize:]
+	if uint32(len(rest)) < h.KeySize+h.ValueSize {
+		return ErrDecodingFailed
+	}
+
+	r.Header = h
+	r.Key = string(rest[:h.KeySize])
+	r.Value = string(rest[h.KeySize : h.KeySize+h.ValueSize])
+	r.RecordSize = headerSize + h.KeySize + h.ValueSize
+	return nil
+}
+
+// VerifyCheckSum reports whether r's header checksum matches its current
+// Key and Value, i.e. whether data (the bytes r was decoded from) is
+// intact.
+func (r *Record) VerifyCheckSum(data []byte) bool {
+	return r.Header.CheckSum == r.CalculateCheckSum()
+}
+
+// validateKV rejects an empty key or a key/value exceeding maxKeySize /
+// maxValueSize, the limits configured via WithMaxKeySize / WithMaxValueSize.
+func validateKV(key string, value []byte, maxKeySize, maxValueSize uint32) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	if uint32(len(key)) > maxKeySize {
+		return ErrKeyTooLarge
+	}
+	if uint32(len(value)) > maxValueSize {
+		return ErrValueTooLarge
+	}
+	return nil
+}
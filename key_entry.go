@@ -0,0 +1,23 @@
+package caskdb
+
+// KeyEntry is the value type stored in KeyDir. It records everything needed
+// to fetch a value straight off disk: which data file it lives in, the byte
+// offset of the record within that file, and the record's total encoded
+// size (header + key + value).
+//
+// KeyEntry deliberately holds a fileID rather than a *os.File: the active
+// file is rotated out as it grows, so a KeyEntry created before a rotation
+// must still resolve to a live, read-only handle for its file rather than a
+// stale or closed one. DiskStore.readFiles maps fileID to that handle.
+type KeyEntry struct {
+	timestamp uint32
+	fileID    uint32
+	position  uint32
+	totalSize uint32
+}
+
+// NewKeyEntry builds a KeyEntry pointing at the record of size totalSize
+// starting at position within data file fileID.
+func NewKeyEntry(timestamp uint32, fileID uint32, position uint32, totalSize uint32) KeyEntry {
+	return KeyEntry{timestamp: timestamp, fileID: fileID, position: position, totalSize: totalSize}
+}
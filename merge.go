@@ -0,0 +1,345 @@
+package caskdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hintSuffix is the extension used for hint files. A hint file sits next to
+// its data file and stores one hintRecord per live key at merge time, so
+// that initKeyDir can rebuild KeyDir without reading every value back off
+// disk.
+const hintSuffix = ".bitcask.hint"
+
+// hintRecord mirrors a KeyEntry but omits the value entirely: it is only
+// ever used to repopulate KeyDir on startup. expiresAt mirrors the
+// record's Header.ExpiresAt (0 if the key has no TTL), so a TTL survives a
+// Merge/restart cycle even though the key itself was rewritten into a new
+// data file at a new offset.
+type hintRecord struct {
+	timestamp uint32
+	keySize   uint32
+	valueSize uint32
+	valuePos  uint32
+	expiresAt uint32
+	key       string
+}
+
+// hintRecordHeaderSize is the fixed-size portion of a hintRecord, i.e.
+// everything except the trailing key bytes.
+const hintRecordHeaderSize = 4 + 4 + 4 + 4 + 4
+
+func encodeHintRecord(h hintRecord) []byte {
+	buf := make([]byte, hintRecordHeaderSize+len(h.key))
+	binary.LittleEndian.PutUint32(buf[0:4], h.timestamp)
+	binary.LittleEndian.PutUint32(buf[4:8], h.keySize)
+	binary.LittleEndian.PutUint32(buf[8:12], h.valueSize)
+	binary.LittleEndian.PutUint32(buf[12:16], h.valuePos)
+	binary.LittleEndian.PutUint32(buf[16:20], h.expiresAt)
+	copy(buf[hintRecordHeaderSize:], h.key)
+	return buf
+}
+
+func decodeHintRecord(r io.Reader) (hintRecord, error) {
+	header := make([]byte, hintRecordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return hintRecord{}, err
+	}
+
+	h := hintRecord{
+		timestamp: binary.LittleEndian.Uint32(header[0:4]),
+		keySize:   binary.LittleEndian.Uint32(header[4:8]),
+		valueSize: binary.LittleEndian.Uint32(header[8:12]),
+		valuePos:  binary.LittleEndian.Uint32(header[12:16]),
+		expiresAt: binary.LittleEndian.Uint32(header[16:20]),
+	}
+
+	key := make([]byte, h.keySize)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return hintRecord{}, err
+	}
+	h.key = string(key)
+
+	return h, nil
+}
+
+func hintFileName(dataFileName string) string {
+	return strings.TrimSuffix(dataFileName, bitcaskDataSuffix) + hintSuffix
+}
+
+// recordDeadBytesLocked credits size dead bytes to fileID, so Merge can
+// later decide which files are worth rewriting. Callers must hold d.mu.
+func (d *DiskStore) recordDeadBytesLocked(fileID uint32, size uint32) {
+	if d.deadBytes == nil {
+		d.deadBytes = make(map[uint32]int64)
+	}
+	d.deadBytes[fileID] += int64(size)
+}
+
+// Merge rewrites every immutable data file into a fresh compacted data
+// file, keeping only the latest live value for each key and dropping
+// tombstones and superseded versions. It emits a hint file next to the
+// compacted data file and swaps keyDir over to it atomically under d.mu.
+// The currently active (still being written to) file is left untouched.
+func (d *DiskStore) Merge() error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	d.mu.Lock()
+	immutable := d.immutableFileIDsLocked()
+	// Reserve mergeID from the same counter createNewDataFile allocates
+	// rotated active file ids from, and do it under this lock: deriving it
+	// from activeFileID instead (activeFileID+1) collides with whatever id
+	// the next rotation hands out, since that rotation also computes
+	// activeFileID+1 independently of any merge that ran in between.
+	var mergeID uint32
+	if len(immutable) > 0 {
+		mergeID = d.nextFileID
+		d.nextFileID++
+	}
+	snapshot := make(map[string]KeyEntry, len(d.keyDir))
+	for k, v := range d.keyDir {
+		snapshot[k] = v
+	}
+	expirySnapshot := make(map[string]uint32, len(d.expiryIndex))
+	for k, v := range d.expiryIndex {
+		expirySnapshot[k] = v
+	}
+	readFiles := make(map[uint32]*os.File, len(immutable))
+	for _, id := range immutable {
+		readFiles[id] = d.readFiles[id]
+	}
+	d.mu.Unlock()
+
+	now := uint32(time.Now().Unix())
+
+	if len(immutable) == 0 {
+		return nil
+	}
+
+	mergeFileName := strconv.FormatUint(uint64(mergeID), 10) + mergedSuffix + bitcaskDataSuffix
+	mergePath := filepath.Join(d.dir, mergeFileName)
+	mergeFile, err := os.Create(mergePath)
+	if err != nil {
+		return fmt.Errorf("caskdb: merge: creating compacted data file: %w", err)
+	}
+
+	var writePosition uint32
+	newEntries := make(map[string]KeyEntry)
+	var hints []hintRecord
+
+	for key, entry := range snapshot {
+		// Only compact entries that live in one of the immutable files we
+		// snapshotted; anything written to the active file after we took
+		// the snapshot is left for the next Merge pass.
+		rf, ok := readFiles[entry.fileID]
+		if !ok {
+			continue
+		}
+
+		// Expired entries are dropped rather than carried forward into the
+		// compacted file; they will be cleaned up in keyDir by the
+		// sweeper or a subsequent Get. The boundary matches
+		// DiskStore.expiredLocked: a key is still live during its own
+		// expiry second.
+		if expiresAt, ok := expirySnapshot[key]; ok && expiresAt != 0 && expiresAt < now {
+			continue
+		}
+
+		data := make([]byte, entry.totalSize)
+		if _, err := rf.ReadAt(data, int64(entry.position)); err != nil {
+			mergeFile.Close()
+			return fmt.Errorf("caskdb: merge: reading %q: %w", key, err)
+		}
+
+		if _, err := mergeFile.Write(data); err != nil {
+			mergeFile.Close()
+			return fmt.Errorf("caskdb: merge: writing %q: %w", key, err)
+		}
+
+		newEntries[key] = NewKeyEntry(entry.timestamp, mergeID, writePosition, entry.totalSize)
+		hints = append(hints, hintRecord{
+			timestamp: entry.timestamp,
+			keySize:   uint32(len(key)),
+			valueSize: entry.totalSize - headerSize - uint32(len(key)),
+			valuePos:  writePosition,
+			expiresAt: expirySnapshot[key],
+			key:       key,
+		})
+		writePosition += entry.totalSize
+	}
+
+	if err := mergeFile.Sync(); err != nil {
+		mergeFile.Close()
+		return fmt.Errorf("caskdb: merge: syncing compacted data file: %w", err)
+	}
+
+	if err := writeHintFile(hintFileName(mergePath), hints); err != nil {
+		mergeFile.Close()
+		return fmt.Errorf("caskdb: merge: writing hint file: %w", err)
+	}
+	mergeFile.Close()
+
+	mergeReadFile, err := os.Open(mergePath)
+	if err != nil {
+		return fmt.Errorf("caskdb: merge: reopening compacted data file: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	immutableSet := make(map[uint32]bool, len(immutable))
+	for _, id := range immutable {
+		immutableSet[id] = true
+	}
+
+	for key, entry := range newEntries {
+		// Only adopt the compacted entry if the key hasn't been overwritten
+		// again while we were merging.
+		if current, ok := d.keyDir[key]; ok && immutableSet[current.fileID] {
+			d.keyDir[key] = entry
+		}
+	}
+	d.readFiles[mergeID] = mergeReadFile
+
+	for _, id := range immutable {
+		delete(d.deadBytes, id)
+		// Recover the file's actual on-disk name from its open handle rather
+		// than reconstructing "<id>.bitcask.data": a file that was itself
+		// produced by an earlier Merge carries mergedSuffix in its name, and
+		// reconstructing the name from the id alone would miss it, leaking
+		// the old merged file and its hint file on every subsequent merge.
+		dataName := readFiles[id].Name()
+		if rf, ok := d.readFiles[id]; ok {
+			rf.Close()
+			delete(d.readFiles, id)
+		}
+		_ = os.Remove(dataName)
+		_ = os.Remove(hintFileName(dataName))
+	}
+
+	return nil
+}
+
+// immutableFileIDsLocked returns the fileIDs of every data file other than
+// the currently active one, oldest first. Callers must hold d.mu.
+func (d *DiskStore) immutableFileIDsLocked() []uint32 {
+	var ids []uint32
+	for id := range d.readFiles {
+		if id == d.activeFileID {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// initKeyDirFromHint rebuilds keyDir entries for fileID from its hint file,
+// which is O(keys) since it never reads record values back off disk.
+func initKeyDirFromHint(d *DiskStore, fileID uint32, hintPath string) error {
+	hf, err := os.Open(hintPath)
+	if err != nil {
+		return err
+	}
+	defer hf.Close()
+
+	for {
+		h, err := decodeHintRecord(hf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		totalSize := headerSize + h.keySize + h.valueSize
+		d.indexEntryLocked(h.key, NewKeyEntry(h.timestamp, fileID, h.valuePos, totalSize))
+		if h.expiresAt != 0 {
+			d.trackExpiryLocked(h.key, h.expiresAt)
+		}
+	}
+
+	return nil
+}
+
+func writeHintFile(path string, hints []hintRecord) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, h := range hints {
+		if _, err := f.Write(encodeHintRecord(h)); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
+
+// AutoMergeOptions configures the optional background merge goroutine
+// started by StartAutoMerge.
+type AutoMergeOptions struct {
+	// Interval is how often the background goroutine wakes up to consider
+	// merging.
+	Interval time.Duration
+	// MinDeadBytes is the minimum number of dead bytes a data file must
+	// accumulate before a merge pass is triggered.
+	MinDeadBytes int64
+}
+
+// StartAutoMerge launches a background goroutine that periodically checks
+// dead-byte statistics and runs Merge whenever any immutable file has
+// accumulated at least opts.MinDeadBytes of dead data. It returns a stop
+// function that terminates the goroutine.
+func (d *DiskStore) StartAutoMerge(opts AutoMergeOptions) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if d.deadBytesExceed(opts.MinDeadBytes) {
+					if err := d.Merge(); err != nil {
+						// Merge failures are not fatal to the store; the
+						// next tick will retry.
+						continue
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+func (d *DiskStore) deadBytesExceed(threshold int64) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for id, dead := range d.deadBytes {
+		// Merge never rewrites the active file, so its dead bytes don't
+		// count towards triggering one.
+		if id == d.activeFileID {
+			continue
+		}
+		if dead >= threshold {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package caskdb
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestMergeThenReopen writes enough keys to force the active file to
+// rotate at least once, leaving one or more immutable files plus the
+// still-open active file, then merges and reopens the store. This is
+// exactly the scenario in which a merged file id colliding with the
+// active file id, or a merged file name the recovery regex can't parse,
+// corrupts or loses data: a key still living in the active file at merge
+// time must read back correctly, and the store itself must still open.
+func TestMergeThenReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	// A tiny max file size forces createNewDataFile to rotate after just a
+	// few writes, so we get at least one immutable file without writing
+	// gigabytes of data.
+	store, err := NewDiskStore(dir, WithMaxFileSize(200))
+	if err != nil {
+		t.Fatalf("NewDiskStore: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		key := "key" + string(rune('a'+i%26)) + string(rune('0'+i/26))
+		if err := store.Set(key, "value-still-in-active-file"); err != nil {
+			t.Fatalf("Set(%q): %v", key, err)
+		}
+	}
+
+	// Overwrite a handful of keys so the files holding their old versions
+	// accumulate dead bytes worth compacting away.
+	for i := 0; i < 10; i++ {
+		key := "key" + string(rune('a'+i%26)) + string(rune('0'+i/26))
+		if err := store.Set(key, "updated"); err != nil {
+			t.Fatalf("re-Set(%q): %v", key, err)
+		}
+	}
+
+	// A key written after Merge snapshots keyDir belongs to the active
+	// file and must never be touched by the merge.
+	if err := store.Set("active-only", "in-the-active-file"); err != nil {
+		t.Fatalf("Set(active-only): %v", err)
+	}
+
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+
+	if v, err := store.Get("active-only"); err != nil || v != "in-the-active-file" {
+		t.Fatalf("Get(active-only) after Merge = %q, %v; want in-the-active-file, nil", v, err)
+	}
+	if v, err := store.Get("keya0"); err != nil || v != "updated" {
+		t.Fatalf("Get(keya0) after Merge = %q, %v; want updated, nil", v, err)
+	}
+
+	if !store.Close() {
+		t.Fatalf("Close returned false")
+	}
+
+	// The real regression: opening a store that has a merged data file on
+	// disk must not fail, and every key must still read back correctly.
+	reopened, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatalf("reopen after merge: %v", err)
+	}
+	defer reopened.Close()
+
+	if v, err := reopened.Get("active-only"); err != nil || v != "in-the-active-file" {
+		t.Fatalf("Get(active-only) after reopen = %q, %v; want in-the-active-file, nil", v, err)
+	}
+	if v, err := reopened.Get("keya0"); err != nil || v != "updated" {
+		t.Fatalf("Get(keya0) after reopen = %q, %v; want updated, nil", v, err)
+	}
+}
+
+// TestMergeTwiceCleansUpPreviousMergedFile merges twice in a row. The
+// first merge's compacted data/hint files carry mergedSuffix in their
+// name; the second merge's cleanup pass must still find and remove them
+// by their actual on-disk name rather than reconstructing "<id>.bitcask.data"
+// from the id alone, which would never match and would leak the file.
+func TestMergeTwiceCleansUpPreviousMergedFile(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewDiskStore(dir, WithMaxFileSize(200))
+	if err != nil {
+		t.Fatalf("NewDiskStore: %v", err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 50; i++ {
+		key := "key" + string(rune('a'+i%26)) + string(rune('0'+i/26))
+		if err := store.Set(key, "v1"); err != nil {
+			t.Fatalf("Set(%q): %v", key, err)
+		}
+	}
+	if err := store.Merge(); err != nil {
+		t.Fatalf("first Merge: %v", err)
+	}
+
+	// Force another rotation and accumulate more dead bytes so the
+	// now-immutable, previously-merged file is itself a candidate for the
+	// second merge's cleanup.
+	for i := 0; i < 50; i++ {
+		key := "key" + string(rune('a'+i%26)) + string(rune('0'+i/26))
+		if err := store.Set(key, "v2"); err != nil {
+			t.Fatalf("re-Set(%q): %v", key, err)
+		}
+	}
+	if err := store.Merge(); err != nil {
+		t.Fatalf("second Merge: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		key := "key" + string(rune('a'+i%26)) + string(rune('0'+i/26))
+		if v, err := store.Get(key); err != nil || v != "v2" {
+			t.Fatalf("Get(%q) after second Merge = %q, %v; want v2, nil", key, v, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	// Exactly one merged data file should exist: the second merge's own
+	// output. The first merge's compacted data file also carries
+	// mergedSuffix, so if cleanup failed to recognize it as its own
+	// previous output, it survives alongside the second merge's file.
+	var mergedDataFiles []string
+	for _, e := range entries {
+		if strings.Contains(e.Name(), mergedSuffix) && strings.HasSuffix(e.Name(), bitcaskDataSuffix) {
+			mergedDataFiles = append(mergedDataFiles, e.Name())
+		}
+	}
+	if len(mergedDataFiles) != 1 {
+		t.Fatalf("merged data files on disk after second Merge = %v, want exactly 1 (the first merge's output leaked)", mergedDataFiles)
+	}
+}
@@ -0,0 +1,146 @@
+package caskdb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Default tunables applied by NewDiskStore when the corresponding Option is
+// not supplied.
+const (
+	defaultMaxFileSize  int64  = 1 << 30 // 1 GiB
+	defaultMaxKeySize   uint32 = 1 << 16 // 64 KiB
+	defaultMaxValueSize uint32 = 1 << 20 // 1 MiB
+)
+
+// lockFileName is the file NewDiskStore flocks to guarantee at most one
+// process has a directory open at a time.
+const lockFileName = "LOCK"
+
+// intervalSyncPeriod is how often the SyncInterval background goroutine
+// fsyncs the active file.
+const intervalSyncPeriod = 200 * time.Millisecond
+
+// ErrDatabaseLocked is returned by NewDiskStore when another process already
+// holds the exclusive lock on dir.
+var ErrDatabaseLocked = errors.New("caskdb: database directory is locked by another process")
+
+// ErrReadOnly is returned by Set, Delete and Merge when the store was opened
+// with WithReadOnly.
+var ErrReadOnly = errors.New("caskdb: store is read-only")
+
+// SyncMode controls when DiskStore flushes writes to disk.
+type SyncMode int
+
+const (
+	// SyncAlways fsyncs after every Set/Delete. This is the safest mode and
+	// the one DiskStore has always used; it caps throughput at whatever the
+	// disk can fsync per second.
+	SyncAlways SyncMode = iota
+	// SyncInterval batches writes and fsyncs on a fixed interval via a
+	// background goroutine, trading some durability window for throughput.
+	SyncInterval
+	// SyncNever never explicitly fsyncs; durability is left entirely to the
+	// OS page cache and Close.
+	SyncNever
+)
+
+// Option configures a DiskStore at construction time. See With* functions.
+type Option func(*DiskStore)
+
+// WithMaxFileSize sets the size at which the active data file is rotated.
+func WithMaxFileSize(n int64) Option {
+	return func(d *DiskStore) { d.maxFileSize = n }
+}
+
+// WithMaxKeySize sets the largest key Set will accept. Delete does not
+// check it.
+func WithMaxKeySize(n uint32) Option {
+	return func(d *DiskStore) { d.maxKeySize = n }
+}
+
+// WithMaxValueSize sets the largest value Set will accept.
+func WithMaxValueSize(n uint32) Option {
+	return func(d *DiskStore) { d.maxValueSize = n }
+}
+
+// WithSyncMode selects when writes are fsynced. See SyncMode.
+func WithSyncMode(mode SyncMode) Option {
+	return func(d *DiskStore) { d.syncMode = mode }
+}
+
+// WithAutoMerge enables a background goroutine that calls Merge whenever an
+// immutable data file has accumulated at least minDeadBytes of dead data,
+// checking every interval.
+func WithAutoMerge(interval time.Duration, minDeadBytes int64) Option {
+	return func(d *DiskStore) {
+		d.autoMerge = AutoMergeOptions{Interval: interval, MinDeadBytes: minDeadBytes}
+	}
+}
+
+// WithReadOnly opens the store without creating or appending to an active
+// data file. Set, Delete and Merge return ErrReadOnly. Unlike a read-write
+// open, a read-only open does not take the exclusive directory lock, so
+// multiple readers (and a single writer) may share a directory.
+func WithReadOnly() Option {
+	return func(d *DiskStore) { d.readOnly = true }
+}
+
+// acquireLock takes an exclusive, non-blocking flock on dir/LOCK, so a
+// second process opening the same directory fails fast with
+// ErrDatabaseLocked instead of silently corrupting data files alongside the
+// first process.
+func acquireLock(dir string) (*os.File, error) {
+	lockFile, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lockFile.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrDatabaseLocked
+		}
+		return nil, err
+	}
+
+	return lockFile, nil
+}
+
+func releaseLock(lockFile *os.File) error {
+	if lockFile == nil {
+		return nil
+	}
+	_ = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+	return lockFile.Close()
+}
+
+// startIntervalSync launches the background goroutine backing SyncInterval:
+// every tick, it fsyncs the active file if there have been writes since the
+// last tick. No writer waits for this fsync: Set and Delete return before
+// their record is durable.
+func (d *DiskStore) startIntervalSync(interval time.Duration) {
+	d.syncStop = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.mu.Lock()
+				if d.dirty && d.file != nil {
+					d.file.Sync()
+					d.dirty = false
+				}
+				d.mu.Unlock()
+			case <-d.syncStop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,47 @@
+package caskdb
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestWithMaxKeySizeRejectsOversizedKey checks that a functional option
+// actually reaches the store's validation path.
+func TestWithMaxKeySizeRejectsOversizedKey(t *testing.T) {
+	store, err := NewDiskStore(t.TempDir(), WithMaxKeySize(4))
+	if err != nil {
+		t.Fatalf("NewDiskStore: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Set("short", "v"); !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("Set with a 5-byte key and WithMaxKeySize(4) = %v; want ErrKeyTooLarge", err)
+	}
+	if err := store.Set("ok", "v"); err != nil {
+		t.Fatalf("Set within the limit: %v", err)
+	}
+}
+
+// TestDirectoryLockRejectsSecondOpen checks that two DiskStores cannot
+// hold the same directory open for writing at once.
+func TestDirectoryLockRejectsSecondOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatalf("first NewDiskStore: %v", err)
+	}
+	defer first.Close()
+
+	if _, err := NewDiskStore(dir); !errors.Is(err, ErrDatabaseLocked) {
+		t.Fatalf("second NewDiskStore on the same dir = %v; want ErrDatabaseLocked", err)
+	}
+
+	// A read-only open does not take the lock, so it must succeed
+	// alongside the writer.
+	reader, err := NewDiskStore(dir, WithReadOnly())
+	if err != nil {
+		t.Fatalf("WithReadOnly NewDiskStore: %v", err)
+	}
+	defer reader.Close()
+}
@@ -0,0 +1,215 @@
+package caskdb
+
+import "sort"
+
+// radixTree is a compressed (PATRICIA-style) trie over key bytes. It
+// mirrors the keys stored in keyDir so that prefix and ordered-range
+// queries can be served in O(prefix length + results) instead of scanning
+// every key in the hash map. It never stores values, only key strings.
+//
+// radixTree is not safe for concurrent use on its own; callers mutate it
+// under DiskStore.mu, the same lock that guards keyDir.
+type radixTree struct {
+	root *radixNode
+}
+
+type radixEdge struct {
+	label byte
+	node  *radixNode
+}
+
+type radixNode struct {
+	// prefix is the slice of key bytes consumed between the parent edge
+	// and this node.
+	prefix string
+	// leaf is true if a key terminates exactly at this node.
+	leaf bool
+	// edges are sorted by label for ordered traversal.
+	edges []radixEdge
+}
+
+func newRadixTree() *radixTree {
+	return &radixTree{root: &radixNode{}}
+}
+
+func (n *radixNode) edgeIndex(label byte) (int, bool) {
+	i := sort.Search(len(n.edges), func(i int) bool { return n.edges[i].label >= label })
+	if i < len(n.edges) && n.edges[i].label == label {
+		return i, true
+	}
+	return i, false
+}
+
+func (n *radixNode) addEdge(e radixEdge) {
+	i, _ := n.edgeIndex(e.label)
+	n.edges = append(n.edges, radixEdge{})
+	copy(n.edges[i+1:], n.edges[i:])
+	n.edges[i] = e
+}
+
+func (n *radixNode) replaceEdge(e radixEdge) {
+	i, ok := n.edgeIndex(e.label)
+	if !ok {
+		panic("caskdb: replaceEdge on missing label")
+	}
+	n.edges[i] = e
+}
+
+func (n *radixNode) delEdge(label byte) {
+	i, ok := n.edgeIndex(label)
+	if !ok {
+		return
+	}
+	n.edges = append(n.edges[:i], n.edges[i+1:]...)
+}
+
+func longestCommonPrefix(a, b string) int {
+	max := len(a)
+	if len(b) < max {
+		max = len(b)
+	}
+	i := 0
+	for i < max && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// Insert adds key to the tree. It is a no-op on the tree's own bookkeeping
+// if key is already present (the node is simply marked as a leaf again).
+func (t *radixTree) Insert(key string) {
+	n := t.root
+	search := key
+
+	for {
+		if len(search) == 0 {
+			n.leaf = true
+			return
+		}
+
+		idx, ok := n.edgeIndex(search[0])
+		if !ok {
+			n.addEdge(radixEdge{label: search[0], node: &radixNode{prefix: search, leaf: true}})
+			return
+		}
+
+		child := n.edges[idx].node
+		commonLen := longestCommonPrefix(search, child.prefix)
+
+		if commonLen == len(child.prefix) {
+			search = search[commonLen:]
+			n = child
+			continue
+		}
+
+		// Split child: insert an intermediate node holding the shared
+		// prefix, with the old child and the new key as its two children.
+		split := &radixNode{prefix: search[:commonLen]}
+		n.replaceEdge(radixEdge{label: search[0], node: split})
+
+		child.prefix = child.prefix[commonLen:]
+		split.addEdge(radixEdge{label: child.prefix[0], node: child})
+
+		search = search[commonLen:]
+		if len(search) == 0 {
+			split.leaf = true
+			return
+		}
+		split.addEdge(radixEdge{label: search[0], node: &radixNode{prefix: search, leaf: true}})
+		return
+	}
+}
+
+// Delete removes key from the tree, if present. It prunes dead-end nodes
+// left behind but does not re-merge single-child chains, which is a purely
+// cosmetic simplification: lookups and ordered walks are unaffected.
+func (t *radixTree) Delete(key string) {
+	type step struct {
+		parent *radixNode
+		label  byte
+	}
+
+	n := t.root
+	search := key
+	var path []step
+
+	for len(search) > 0 {
+		idx, ok := n.edgeIndex(search[0])
+		if !ok {
+			return
+		}
+		child := n.edges[idx].node
+		if longestCommonPrefix(search, child.prefix) != len(child.prefix) {
+			return
+		}
+		path = append(path, step{parent: n, label: search[0]})
+		search = search[len(child.prefix):]
+		n = child
+	}
+
+	if !n.leaf {
+		return
+	}
+	n.leaf = false
+
+	for i := len(path) - 1; i >= 0 && len(n.edges) == 0 && !n.leaf; i-- {
+		parent := path[i].parent
+		parent.delEdge(path[i].label)
+		n = parent
+	}
+}
+
+// Walk visits every key in the tree in ascending lexicographic order,
+// stopping early if fn returns false.
+func (t *radixTree) Walk(fn func(key string) bool) {
+	walkNode(t.root, "", fn)
+}
+
+func walkNode(n *radixNode, accumulated string, fn func(key string) bool) bool {
+	if n.leaf {
+		if !fn(accumulated) {
+			return false
+		}
+	}
+	for _, e := range n.edges {
+		if !walkNode(e.node, accumulated+e.node.prefix, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// WalkPrefix visits every key with the given prefix in ascending order,
+// stopping early if fn returns false. It descends directly to the subtree
+// holding the prefix, so cost is O(len(prefix) + matches) rather than
+// O(total keys).
+func (t *radixTree) WalkPrefix(prefix string, fn func(key string) bool) {
+	n := t.root
+	search := prefix
+
+	for {
+		if len(search) == 0 {
+			walkNode(n, prefix, fn)
+			return
+		}
+
+		idx, ok := n.edgeIndex(search[0])
+		if !ok {
+			return
+		}
+		child := n.edges[idx].node
+
+		if len(search) <= len(child.prefix) {
+			if child.prefix[:len(search)] == search {
+				walkNode(child, prefix+child.prefix[len(search):], fn)
+			}
+			return
+		}
+
+		if search[:len(child.prefix)] != child.prefix {
+			return
+		}
+		search = search[len(child.prefix):]
+		n = child
+	}
+}
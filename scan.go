@@ -0,0 +1,147 @@
+package caskdb
+
+import "errors"
+
+// indexEntryLocked records entry under key in both keyDir and the radix
+// tree that mirrors it. Callers must hold d.mu for writing.
+func (d *DiskStore) indexEntryLocked(key string, entry KeyEntry) {
+	d.keyDir[key] = entry
+	d.keys.Insert(key)
+}
+
+// removeEntryLocked removes key from both keyDir and the radix tree.
+// Callers must hold d.mu for writing.
+func (d *DiskStore) removeEntryLocked(key string) {
+	delete(d.keyDir, key)
+	d.keys.Delete(key)
+}
+
+// Scan returns every key currently stored with the given prefix, in
+// ascending lexicographic order. It is backed by a radix tree mirrored
+// alongside keyDir, so cost is O(len(prefix) + len(result)) rather than a
+// full scan of all keys.
+func (d *DiskStore) Scan(prefix string) []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var result []string
+	d.keys.WalkPrefix(prefix, func(key string) bool {
+		result = append(result, key)
+		return true
+	})
+	return result
+}
+
+// Range calls fn for every key k with start <= k < end, in ascending
+// order, stopping as soon as fn returns false or k reaches end.
+func (d *DiskStore) Range(start, end string, fn func(k, v string) bool) error {
+	var rangeErr error
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	d.keys.Walk(func(key string) bool {
+		if key < start {
+			return true
+		}
+		if key >= end {
+			return false
+		}
+
+		value, err := d.getLocked(key)
+		if err != nil {
+			if errors.Is(err, ErrKeyNotFound) {
+				return true
+			}
+			rangeErr = err
+			return false
+		}
+		return fn(key, value)
+	})
+
+	return rangeErr
+}
+
+// Fold calls fn for every key in the store, in ascending order, stopping
+// as soon as fn returns false.
+func (d *DiskStore) Fold(fn func(k, v string) bool) error {
+	var foldErr error
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	d.keys.Walk(func(key string) bool {
+		value, err := d.getLocked(key)
+		if err != nil {
+			if errors.Is(err, ErrKeyNotFound) {
+				return true
+			}
+			foldErr = err
+			return false
+		}
+		return fn(key, value)
+	})
+
+	return foldErr
+}
+
+// Iterator streams over a pre-computed, ascending-order set of keys,
+// fetching each value lazily from disk only once Next has advanced to it.
+// This lets callers process result sets larger than memory without
+// materialising every value up front.
+type Iterator struct {
+	store *DiskStore
+	keys  []string
+	pos   int
+
+	key   string
+	value string
+	err   error
+}
+
+// ScanIterator is the streaming counterpart to Scan: it walks the same
+// radix subtree but defers fetching each value until Next is called.
+func (d *DiskStore) ScanIterator(prefix string) *Iterator {
+	return &Iterator{store: d, keys: d.Scan(prefix)}
+}
+
+// Next advances the iterator and reports whether a Key/Value pair is
+// available. Keys deleted between the scan and the call to Next are
+// skipped rather than surfaced as an error.
+func (it *Iterator) Next() bool {
+	for it.pos < len(it.keys) {
+		key := it.keys[it.pos]
+		it.pos++
+
+		value, err := it.store.Get(key)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.key = key
+		it.value = value
+		return true
+	}
+	return false
+}
+
+// Key returns the key at the iterator's current position.
+func (it *Iterator) Key() string { return it.key }
+
+// Value returns the value at the iterator's current position.
+func (it *Iterator) Value() string { return it.value }
+
+// Err returns the first error encountered by Next, if any.
+func (it *Iterator) Err() error { return it.err }
+
+// Close releases the iterator. It is always safe to call and never
+// returns an error; it exists so callers can use Iterator in a defer
+// alongside other io.Closer-like resources.
+func (it *Iterator) Close() error {
+	it.pos = len(it.keys)
+	return nil
+}
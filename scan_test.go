@@ -0,0 +1,79 @@
+package caskdb
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestScanRangeFoldAndIterator(t *testing.T) {
+	store, err := NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskStore: %v", err)
+	}
+	defer store.Close()
+
+	keys := []string{"fruit/apple", "fruit/banana", "fruit/cherry", "veg/carrot"}
+	for _, k := range keys {
+		if err := store.Set(k, k+"-value"); err != nil {
+			t.Fatalf("Set(%q): %v", k, err)
+		}
+	}
+
+	got := store.Scan("fruit/")
+	sort.Strings(got)
+	want := []string{"fruit/apple", "fruit/banana", "fruit/cherry"}
+	if !equalStrings(got, want) {
+		t.Fatalf("Scan(fruit/) = %v, want %v", got, want)
+	}
+
+	var ranged []string
+	if err := store.Range("fruit/banana", "fruit/zzzz", func(k, v string) bool {
+		ranged = append(ranged, k)
+		return true
+	}); err != nil {
+		t.Fatalf("Range: %v", err)
+	}
+	wantRange := []string{"fruit/banana", "fruit/cherry"}
+	if !equalStrings(ranged, wantRange) {
+		t.Fatalf("Range(fruit/banana, fruit/zzzz) = %v, want %v", ranged, wantRange)
+	}
+
+	var folded []string
+	if err := store.Fold(func(k, v string) bool {
+		folded = append(folded, k)
+		return true
+	}); err != nil {
+		t.Fatalf("Fold: %v", err)
+	}
+	sort.Strings(folded)
+	wantAll := append(append([]string{}, keys[:3]...), keys[3])
+	sort.Strings(wantAll)
+	if !equalStrings(folded, wantAll) {
+		t.Fatalf("Fold = %v, want %v", folded, wantAll)
+	}
+
+	it := store.ScanIterator("fruit/")
+	var iterated []string
+	for it.Next() {
+		iterated = append(iterated, it.Key())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Iterator.Err: %v", err)
+	}
+	sort.Strings(iterated)
+	if !equalStrings(iterated, want) {
+		t.Fatalf("ScanIterator(fruit/) = %v, want %v", iterated, want)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,212 @@
+package caskdb
+
+import (
+	"bytes"
+	"container/heap"
+	"time"
+)
+
+// ttlSweepInterval is how often the background TTL sweeper wakes up to
+// evict keys whose TTL has passed, in expiration order.
+const ttlSweepInterval = 200 * time.Millisecond
+
+// expiryItem is one entry in the expiry heap: a key and the absolute Unix
+// timestamp at which it is due to expire.
+type expiryItem struct {
+	key       string
+	expiresAt uint32
+}
+
+// expiryHeap is a min-heap of expiryItem ordered by expiresAt, so the
+// sweeper can always find the next key due to expire without scanning
+// keyDir. A key's TTL can be overwritten (SetWithTTL, Expire) or cleared
+// (Set, Delete) without removing its old entries from the heap; those
+// entries are left in place and discarded, rather than evicted, once
+// popped and found to no longer match expiryIndex.
+type expiryHeap []expiryItem
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt < h[j].expiresAt }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x interface{}) {
+	*h = append(*h, x.(expiryItem))
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// trackExpiryLocked records that key now expires at expiresAt and pushes a
+// new heap entry for it. Callers must hold d.mu for writing. expiresAt
+// must be non-zero; use clearExpiryLocked to drop a key's TTL.
+func (d *DiskStore) trackExpiryLocked(key string, expiresAt uint32) {
+	if d.expiryIndex == nil {
+		d.expiryIndex = make(map[string]uint32)
+	}
+	d.expiryIndex[key] = expiresAt
+	heap.Push(&d.expiry, expiryItem{key: key, expiresAt: expiresAt})
+}
+
+// clearExpiryLocked drops key's TTL, if any. Any heap entries already
+// pushed for key are left in place and discarded lazily when popped.
+// Callers must hold d.mu for writing.
+func (d *DiskStore) clearExpiryLocked(key string) {
+	delete(d.expiryIndex, key)
+}
+
+// expiredLocked reports whether key currently has a TTL that has passed.
+// The comparison is strict (< rather than <=): expiresAt is the whole
+// second during which the key is still live, so a key expires once the
+// clock has moved into the next second, not at the first tick of its own
+// expiry second. Callers must hold d.mu for reading or writing.
+func (d *DiskStore) expiredLocked(key string) bool {
+	expiresAt, ok := d.expiryIndex[key]
+	return ok && expiresAt < uint32(time.Now().Unix())
+}
+
+// expiresAtFromNow converts ttl to an absolute Unix-seconds expiry that is
+// guaranteed not to be in the past relative to time.Now(): the header only
+// stores whole seconds, so a ttl with a fractional remainder (anything
+// under a second, or not landing exactly on a second boundary) is rounded
+// up rather than truncated. Truncating would let a short TTL's expiry
+// collapse onto the current second and read as already-expired the
+// instant it was written.
+func expiresAtFromNow(ttl time.Duration) uint32 {
+	t := time.Now().Add(ttl)
+	expiresAt := t.Unix()
+	if t.Nanosecond() > 0 {
+		expiresAt++
+	}
+	return uint32(expiresAt)
+}
+
+// SetWithTTL stores key/value like Set, but the record expires ttl from
+// now: once its expiry passes, Get treats the key as absent, and the
+// background sweeper evicts it in expiration order without waiting for a
+// Get to trigger lazy eviction.
+func (d *DiskStore) SetWithTTL(key string, value string, ttl time.Duration) error {
+	return d.set(key, value, expiresAtFromNow(ttl))
+}
+
+// Expire sets or replaces the TTL on an existing key without changing its
+// value, so it expires ttl from now. It returns ErrKeyNotFound if key is
+// absent or already expired.
+func (d *DiskStore) Expire(key string, ttl time.Duration) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	d.mu.RLock()
+	value, err := d.getLocked(key)
+	d.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return d.set(key, value, expiresAtFromNow(ttl))
+}
+
+// evictExpired physically removes key if it is still expired at the time
+// of the call, writing a tombstone so the deletion survives recovery, the
+// same as Delete does. It is safe to call speculatively: a key that was
+// refreshed or already evicted between an earlier expiry check and this
+// call is left untouched. On a read-only store, where nothing can be
+// written, it only drops the in-memory index entries.
+func (d *DiskStore) evictExpired(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.expiredLocked(key) {
+		return
+	}
+
+	if d.readOnly {
+		d.removeEntryLocked(key)
+		d.clearExpiryLocked(key)
+		return
+	}
+
+	timestamp := uint32(time.Now().Unix())
+	h := Header{TimeStamp: timestamp, KeySize: uint32(len(key))}
+	h.MarkTombStone()
+	r := Record{Header: h, Key: key, RecordSize: headerSize + h.KeySize}
+	r.Header.CheckSum = r.CalculateCheckSum()
+
+	buf := new(bytes.Buffer)
+	if err := r.EncodeKV(buf); err != nil {
+		return
+	}
+
+	if err := d.write(buf.Bytes()); err != nil {
+		return
+	}
+	d.writePosition += int(r.Size())
+
+	if old, ok := d.keyDir[key]; ok {
+		d.recordDeadBytesLocked(old.fileID, old.totalSize)
+	}
+
+	d.removeEntryLocked(key)
+	d.clearExpiryLocked(key)
+}
+
+// startTTLSweeper launches the background goroutine that evicts keys in
+// expiration order using the expiry heap, so TTLs are reclaimed without
+// waiting for a Get to trigger lazy eviction. It returns a stop function
+// that terminates the goroutine.
+func (d *DiskStore) startTTLSweeper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.sweepExpired()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// sweepExpired evicts every key at the front of the expiry heap whose TTL
+// has passed, stopping as soon as the heap's minimum is still in the
+// future. Heap entries left stale by an overwritten or cleared TTL are
+// discarded without eviction.
+func (d *DiskStore) sweepExpired() {
+	now := uint32(time.Now().Unix())
+
+	for {
+		d.mu.Lock()
+		if d.expiry.Len() == 0 {
+			d.mu.Unlock()
+			return
+		}
+		item := d.expiry[0]
+		// Mirrors expiredLocked's strict boundary: the key is still live
+		// during its own expiry second, so only pop items whose expiry
+		// second is already behind us.
+		if item.expiresAt >= now {
+			d.mu.Unlock()
+			return
+		}
+		heap.Pop(&d.expiry)
+		current, ok := d.expiryIndex[item.key]
+		evict := ok && current == item.expiresAt
+		d.mu.Unlock()
+
+		if evict {
+			d.evictExpired(item.key)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package caskdb
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestHeaderRoundTripExpiresAt checks that ExpiresAt survives an
+// encode/decode cycle, since Get's expiry check and recovery's heap
+// rebuild both depend on reading it back unchanged.
+func TestHeaderRoundTripExpiresAt(t *testing.T) {
+	r := Record{
+		Header: Header{TimeStamp: 1, KeySize: 1, ValueSize: 1, ExpiresAt: 1700000000},
+		Key:    "k",
+		Value:  "v",
+	}
+	r.RecordSize = headerSize + r.Header.KeySize + r.Header.ValueSize
+	r.Header.CheckSum = r.CalculateCheckSum()
+
+	var buf bytes.Buffer
+	if err := r.EncodeKV(&buf); err != nil {
+		t.Fatalf("EncodeKV: %v", err)
+	}
+
+	var got Record
+	if err := got.DecodeKV(buf.Bytes()); err != nil {
+		t.Fatalf("DecodeKV: %v", err)
+	}
+
+	if got.Header.ExpiresAt != 1700000000 {
+		t.Fatalf("ExpiresAt did not round-trip: got %d, want 1700000000", got.Header.ExpiresAt)
+	}
+}
+
+// ttlRoundingMargin is how long a test must wait, beyond the nominal TTL
+// it set, before it can assume expiry has been observed: expiresAtFromNow
+// rounds up to the next whole second (up to 1s), and expiredLocked's
+// boundary is strict (the key is still live during its own expiry second,
+// up to another 1s). A test racing the second boundary with a sub-second
+// TTL would be exactly the non-functional case this rounding fixes, so
+// every test below that waits for a TTL to pass uses a TTL of whole
+// seconds, not milliseconds.
+const ttlRoundingMargin = 2500 * time.Millisecond
+
+// TestSetWithTTLExpires checks that a key written with SetWithTTL is
+// readable before its TTL passes and is treated as absent afterwards.
+func TestSetWithTTLExpires(t *testing.T) {
+	store, err := NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskStore: %v", err)
+	}
+	defer store.Close()
+
+	const ttl = 2 * time.Second
+
+	if err := store.SetWithTTL("k", "v", ttl); err != nil {
+		t.Fatalf("SetWithTTL: %v", err)
+	}
+
+	if v, err := store.Get("k"); err != nil || v != "v" {
+		t.Fatalf("Get before expiry = %q, %v; want v, nil", v, err)
+	}
+
+	time.Sleep(ttl + ttlRoundingMargin)
+
+	if _, err := store.Get("k"); err != ErrKeyNotFound {
+		t.Fatalf("Get after expiry = %v; want ErrKeyNotFound", err)
+	}
+}
+
+// TestSetWithTTLSurvivesReopen checks that a not-yet-expired TTL is
+// rebuilt from the data file on recovery, so a key doesn't become
+// permanent just because the store was restarted.
+func TestSetWithTTLSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatalf("NewDiskStore: %v", err)
+	}
+
+	const ttl = 2 * time.Second
+
+	if err := store.SetWithTTL("k", "v", ttl); err != nil {
+		t.Fatalf("SetWithTTL: %v", err)
+	}
+	if !store.Close() {
+		t.Fatalf("Close returned false")
+	}
+
+	reopened, err := NewDiskStore(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+
+	if v, err := reopened.Get("k"); err != nil || v != "v" {
+		t.Fatalf("Get right after reopen = %q, %v; want v, nil", v, err)
+	}
+
+	time.Sleep(ttl + ttlRoundingMargin)
+
+	if _, err := reopened.Get("k"); err != ErrKeyNotFound {
+		t.Fatalf("Get after the TTL set before reopen elapsed = %v; want ErrKeyNotFound", err)
+	}
+}
+
+// TestExpiresAtFromNowRoundsUp checks that a TTL is never rounded down to
+// a whole-second expiry in the past relative to the instant it was
+// computed from: truncating instead of rounding up is exactly what made a
+// sub-second TTL expire the moment it was written.
+func TestExpiresAtFromNowRoundsUp(t *testing.T) {
+	before := time.Now()
+	expiresAt := expiresAtFromNow(500 * time.Millisecond)
+	target := before.Add(500 * time.Millisecond)
+
+	if got := time.Unix(int64(expiresAt), 0); got.Before(target) {
+		t.Fatalf("expiresAtFromNow(500ms) = %v, want an instant no earlier than %v", got, target)
+	}
+}
+
+// TestExpiredLockedBoundary checks expiredLocked's deliberately strict (<)
+// boundary: a key is still considered live during its own expiry second,
+// and only reports expired once the clock has moved into the next one.
+func TestExpiredLockedBoundary(t *testing.T) {
+	store, err := NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskStore: %v", err)
+	}
+	defer store.Close()
+
+	now := uint32(time.Now().Unix())
+
+	store.mu.Lock()
+	store.expiryIndex = map[string]uint32{
+		"past":    now - 1,
+		"current": now,
+		"future":  now + 10,
+	}
+	pastExpired := store.expiredLocked("past")
+	currentExpired := store.expiredLocked("current")
+	futureExpired := store.expiredLocked("future")
+	store.mu.Unlock()
+
+	if !pastExpired {
+		t.Fatalf("expiredLocked(expiresAt=now-1) = false; want true")
+	}
+	if currentExpired {
+		t.Fatalf("expiredLocked(expiresAt=now) = true; want false (still live during its own expiry second)")
+	}
+	if futureExpired {
+		t.Fatalf("expiredLocked(expiresAt=now+10) = true; want false")
+	}
+}